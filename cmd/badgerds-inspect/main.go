@@ -0,0 +1,211 @@
+// Command badgerds-inspect lists, reads and stats the datastores of an
+// IPFS repo's datastore_spec without migrating anything, reusing
+// badgerds-upgrade's version-detection layer.
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/dms3-fs/badgerds-upgrade/upgrade"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "list":
+		err = runList(os.Args[2:])
+	case "get":
+		err = runGet(os.Args[2:])
+	case "stat":
+		err = runStat(os.Args[2:])
+	default:
+		usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <list|get|stat> [flags] <repo path> [args]\n", os.Args[0])
+}
+
+func runList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	prefix := fs.String("prefix", "", "only list keys with this hex-encoded prefix")
+	values := fs.String("values", "hex", "how to print values: hex, esc or cbor")
+	topLevel := fs.Bool("top-level", false, "list the repo's datastore mounts instead of their contents")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("list takes exactly one <repo path> argument")
+	}
+	repoPath := fs.Arg(0)
+
+	mounts, err := upgrade.Mounts(repoPath)
+	if err != nil {
+		return err
+	}
+
+	if *topLevel {
+		for _, m := range mounts {
+			fmt.Printf("%s\t%s\n", m.Type, m.Path)
+		}
+		return nil
+	}
+
+	prefixBytes, err := hex.DecodeString(*prefix)
+	if err != nil {
+		return fmt.Errorf("--prefix must be hex: %w", err)
+	}
+
+	for _, m := range mounts {
+		if m.Type != "badgerds" {
+			fmt.Fprintf(os.Stderr, "skipping %s mount at %s: not a badger datastore\n", m.Type, m.Path)
+			continue
+		}
+
+		ds, err := upgrade.Open(m.Path)
+		if err != nil {
+			return err
+		}
+
+		data, err := ds.List(prefixBytes)
+		if err != nil {
+			return err
+		}
+
+		for kv := range data {
+			fmt.Printf("%s\t%s\n", hex.EncodeToString(kv.Key), formatValue(kv.Value, *values))
+		}
+		ds.Close()
+	}
+	return nil
+}
+
+func runGet(args []string) error {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	values := fs.String("values", "hex", "how to print the value: hex, esc or cbor")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return fmt.Errorf("get takes exactly two arguments: <repo path> <key>")
+	}
+	repoPath, keyHex := fs.Arg(0), fs.Arg(1)
+
+	key, err := hex.DecodeString(keyHex)
+	if err != nil {
+		return fmt.Errorf("key must be hex: %w", err)
+	}
+
+	mounts, err := upgrade.Mounts(repoPath)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range mounts {
+		if m.Type != "badgerds" {
+			continue
+		}
+
+		ds, err := upgrade.Open(m.Path)
+		if err != nil {
+			return err
+		}
+
+		value, ok, err := ds.Get(key)
+		ds.Close()
+		if err != nil {
+			return err
+		}
+		if ok {
+			fmt.Println(formatValue(value, *values))
+			return nil
+		}
+	}
+
+	return fmt.Errorf("key not found in any badger mount")
+}
+
+func runStat(args []string) error {
+	fs := flag.NewFlagSet("stat", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("stat takes exactly one <repo path> argument")
+	}
+	repoPath := fs.Arg(0)
+
+	mounts, err := upgrade.Mounts(repoPath)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range mounts {
+		if m.Type != "badgerds" {
+			size, err := upgrade.DirSize(m.Path)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s\t%s\tbytes=%d\n", m.Type, m.Path, size)
+			continue
+		}
+
+		ds, err := upgrade.Open(m.Path)
+		if err != nil {
+			return err
+		}
+
+		stat, err := ds.Stat()
+		ds.Close()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\tversion=%s\tentries=%d\tbytes=%d\n", stat.Path, stat.Version, stat.Entries, stat.Bytes)
+	}
+	return nil
+}
+
+// formatValue renders value per --values. "cbor" covers dag-cbor-ish
+// IPFS values (pins, some block formats): it decodes generically and
+// re-marshals as JSON for readability, falling back to hex for values
+// that aren't valid CBOR at all rather than failing the whole command.
+func formatValue(value []byte, mode string) string {
+	switch mode {
+	case "esc":
+		return fmt.Sprintf("%q", value)
+	case "cbor":
+		// Decode maps with string keys; the default interface{}-keyed maps
+		// are rejected by json.Marshal.
+		dm, err := cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]interface{}(nil))}.DecMode()
+		if err != nil {
+			return fmt.Sprintf("<cbor decoder: %s> %s", err, hex.EncodeToString(value))
+		}
+		var v interface{}
+		if err := dm.Unmarshal(value, &v); err != nil {
+			return fmt.Sprintf("<not cbor: %s> %s", err, hex.EncodeToString(value))
+		}
+		out, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("<cbor value not json-representable: %s> %s", err, hex.EncodeToString(value))
+		}
+		return string(out)
+	default:
+		return hex.EncodeToString(value)
+	}
+}
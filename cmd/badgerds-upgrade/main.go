@@ -0,0 +1,60 @@
+// Command badgerds-upgrade walks the badger datastores of an IPFS repo
+// from whatever on-disk format they are in up to a target badger version.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/dms3-fs/badgerds-upgrade/upgrade"
+)
+
+func main() {
+	targetVersion := flag.String("target-version", "", "badger version to upgrade to (default: newest supported)")
+	export := flag.String("export", "", "export to <format>:<path> (car or tar) instead of upgrading in place")
+	resume := flag.Bool("resume", false, "resume a migration from its last checkpoint, if any")
+	restart := flag.Bool("restart", false, "discard any existing checkpoint and start the migration over")
+	workers := flag.Int("workers", 4, "number of concurrent reader/writer goroutines per hop")
+	batchBytes := flag.Int64("batch-bytes", 64<<20, "cumulative key+value bytes a writer commits per batch (hops into badger 1 only)")
+	sync := flag.Bool("sync", true, "fsync every write batch")
+	verify := flag.Bool("verify", false, "verify migrated data against the pre-migration backup and write upgrade-manifest.json")
+	manifestOnly := flag.Bool("manifest-only", false, "compute upgrade-manifest.json for the repo's current datastores without upgrading")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <repo path>\n", os.Args[0])
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if *manifestOnly {
+		if err := upgrade.Verify(ctx, flag.Arg(0)); err != nil {
+			upgrade.Log.Printf("Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	opts := []upgrade.Option{
+		upgrade.WithTargetVersion(*targetVersion),
+		upgrade.WithExportTo(*export),
+		upgrade.WithResume(*resume),
+		upgrade.WithRestart(*restart),
+		upgrade.WithWorkers(*workers),
+		upgrade.WithBatchBytes(*batchBytes),
+		upgrade.WithSyncWrites(*sync),
+		upgrade.WithVerifyAfterUpgrade(*verify),
+	}
+
+	if err := upgrade.Upgrade(ctx, flag.Arg(0), opts...); err != nil {
+		upgrade.Log.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,217 @@
+package upgrade
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const checkpointFile = ".badgerds-upgrade.state"
+
+// checkpointEntry records enough about one in-flight Stepper hop to
+// resume it: which temp dir already holds partial output, how far the
+// source Export got, and how many entries were committed so far.
+type checkpointEntry struct {
+	Step    int    `json:"step"`
+	TempDir string `json:"tempDir"`
+	LastKey string `json:"lastKey"` // hex-encoded, empty if nothing committed yet
+	Count   int    `json:"count"`
+}
+
+// checkpointState is keyed by the source datastore path being migrated.
+type checkpointState map[string]checkpointEntry
+
+func checkpointPath(repoPath string) string {
+	return filepath.Join(repoPath, checkpointFile)
+}
+
+func loadCheckpointState(repoPath string) (checkpointState, error) {
+	data, err := ioutil.ReadFile(checkpointPath(repoPath))
+	if os.IsNotExist(err) {
+		return checkpointState{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := checkpointState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// saveCheckpointState writes state atomically via write-temp+rename, so a
+// crash mid-write never leaves a corrupt state file behind.
+func saveCheckpointState(repoPath string, state checkpointState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(repoPath, ".badgerds-upgrade.state-")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	return os.Rename(tmpName, checkpointPath(repoPath))
+}
+
+// cleanupStaleTempDirs removes "badger-*" dirs left behind by a crashed
+// migration that no checkpoint entry references any more.
+func cleanupStaleTempDirs(repoPath string, state checkpointState) error {
+	live := map[string]struct{}{}
+	for _, e := range state {
+		live[e.TempDir] = struct{}{}
+	}
+
+	entries, err := ioutil.ReadDir(repoPath)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range entries {
+		if !fi.IsDir() || !strings.HasPrefix(fi.Name(), "badger-") || strings.HasPrefix(fi.Name(), "badger-backup-") {
+			continue
+		}
+
+		full := filepath.Join(repoPath, fi.Name())
+		if _, ok := live[full]; ok {
+			continue
+		}
+
+		Log.Printf("Removing stale temp dir %s\n", full)
+		if err := os.RemoveAll(full); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkpointTracker derives a resumable low-water mark for one hop's
+// in-flight import. It does not learn progress from the source side (a
+// Stepper.Export feeding the sink) because a key handed off to an import
+// worker is not yet durable: the worker may still be holding it in an
+// uncommitted transaction when the process crashes. Instead, each import
+// worker reports its own commits through onCommit (wired in via
+// withCommitCallback), and the checkpoint only ever advances to the
+// minimum across every worker's last commit — the newest key provably
+// safe to resume after, even though workers commit out of source order.
+type checkpointTracker struct {
+	repoPath string
+	srcPath  string
+	state    checkpointState
+	entry    checkpointEntry
+
+	mu         sync.Mutex
+	watermarks [][]byte // per worker index; nil until that worker's first commit
+	count      int64
+	interval   time.Duration
+	lastFlush  time.Time
+}
+
+func newCheckpointTracker(repoPath, srcPath string, state checkpointState, entry checkpointEntry, workers int) *checkpointTracker {
+	return &checkpointTracker{
+		repoPath:   repoPath,
+		srcPath:    srcPath,
+		state:      state,
+		entry:      entry,
+		watermarks: make([][]byte, workers),
+		count:      int64(entry.Count),
+		interval:   10 * time.Second,
+		lastFlush:  time.Now(),
+	}
+}
+
+// onCommit records that importer worker idx has durably committed every
+// entry up to and including key. It is a commitFunc, passed to Stepper
+// Import implementations via the context (see withCommitCallback).
+func (t *checkpointTracker) onCommit(idx int, key []byte, delta int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.watermarks[idx] = append([]byte(nil), key...)
+
+	before := t.count
+	t.count += int64(delta)
+
+	low := t.watermarks[0]
+	for _, k := range t.watermarks[1:] {
+		if k == nil {
+			low = nil
+			break
+		}
+		if bytes.Compare(k, low) < 0 {
+			low = k
+		}
+	}
+	if low == nil {
+		// Not every worker has committed yet, so no key is provably safe
+		// to resume after.
+		return
+	}
+
+	t.entry.LastKey = hex.EncodeToString(low)
+	t.entry.Count = int(t.count)
+
+	if before/1000 != t.count/1000 || time.Since(t.lastFlush) > t.interval {
+		t.flushLocked()
+	}
+}
+
+func (t *checkpointTracker) flushLocked() error {
+	t.state[t.srcPath] = t.entry
+	if err := saveCheckpointState(t.repoPath, t.state); err != nil {
+		return err
+	}
+	t.lastFlush = time.Now()
+	return nil
+}
+
+func (t *checkpointTracker) flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.flushLocked()
+}
+
+// checkpointSink wraps another Sink, flushing its checkpointTracker's
+// state once Close confirms every import worker's last commit has
+// actually landed (Sink.Close, for badgerSink, blocks until Import
+// returns). On the success path upgradeDs deletes this hop's checkpoint
+// entry right after, so this final flush only matters if the hop failed
+// or was cancelled partway through.
+type checkpointSink struct {
+	Sink
+	tracker *checkpointTracker
+}
+
+func (s *checkpointSink) Close() error {
+	err := s.Sink.Close()
+	if ferr := s.tracker.flush(); ferr != nil && err == nil {
+		err = ferr
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+package upgrade
+
+import "testing"
+
+func TestCheckpointTrackerLowWaterMark(t *testing.T) {
+	state := checkpointState{}
+	tr := newCheckpointTracker("/tmp", "/src", state, checkpointEntry{Step: 0, TempDir: "/tmp/badger-x"}, 2)
+
+	// Worker 1 races ahead and commits a high key before worker 0 commits
+	// its own, lower one. The tracker must not advance past worker 0's
+	// key, since a crash right now would lose worker 0's still-pending
+	// entries if it did.
+	tr.onCommit(1, []byte{0x80}, 3)
+	if tr.entry.LastKey != "" {
+		t.Fatalf("LastKey advanced to %q before every worker had committed", tr.entry.LastKey)
+	}
+
+	tr.onCommit(0, []byte{0x10}, 2)
+	if got, want := tr.entry.LastKey, "10"; got != want {
+		t.Fatalf("LastKey = %q, want %q (worker 0's lower commit)", got, want)
+	}
+	if tr.entry.Count != 5 {
+		t.Fatalf("Count = %d, want 5", tr.entry.Count)
+	}
+
+	// Worker 0 catches up past worker 1's earlier commit; the low-water
+	// mark should now follow worker 1's still-unmoved watermark.
+	tr.onCommit(0, []byte{0x90}, 1)
+	if got, want := tr.entry.LastKey, "80"; got != want {
+		t.Fatalf("LastKey = %q, want %q (worker 1's commit, now the minimum)", got, want)
+	}
+}
+
+func TestCheckpointTrackerFlushPersists(t *testing.T) {
+	dir := t.TempDir()
+	state := checkpointState{}
+	tr := newCheckpointTracker(dir, "/src", state, checkpointEntry{Step: 0, TempDir: dir + "/badger-x"}, 1)
+
+	tr.onCommit(0, []byte{0x42}, 7)
+	if err := tr.flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	loaded, err := loadCheckpointState(dir)
+	if err != nil {
+		t.Fatalf("loadCheckpointState: %v", err)
+	}
+	entry, ok := loaded["/src"]
+	if !ok {
+		t.Fatalf("no checkpoint entry for /src in %v", loaded)
+	}
+	if entry.LastKey != "42" || entry.Count != 7 {
+		t.Fatalf("entry = %+v, want LastKey=42 Count=7", entry)
+	}
+}
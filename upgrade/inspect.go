@@ -0,0 +1,209 @@
+package upgrade
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// MountInfo describes one datastore mount found in datastore_spec,
+// regardless of whether badgerds-upgrade knows how to open it. Unlike
+// parseSpecs (which Upgrade and Verify use and which has no use for anything
+// but badgerds paths), this also surfaces flatfs and levelds mounts so
+// inspection tooling can at least report that they exist.
+type MountInfo struct {
+	Type string
+	Path string
+}
+
+// Mounts returns every datastore mount referenced by baseDir's
+// datastore_spec, with each Path joined onto baseDir the same way Upgrade
+// and Verify resolve them.
+func Mounts(baseDir string) ([]MountInfo, error) {
+	p := &Process{path: baseDir}
+	if err := p.checkRepoVersion(); err != nil {
+		return nil, err
+	}
+
+	specData, err := ioutil.ReadFile(filepath.Join(p.path, SpecsFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var spec map[string]interface{}
+	if err := json.Unmarshal(specData, &spec); err != nil {
+		return nil, err
+	}
+
+	mounts, err := collectMounts(spec)
+	if err != nil {
+		return nil, err
+	}
+	for i := range mounts {
+		mounts[i].Path = filepath.Join(p.path, mounts[i].Path)
+	}
+	return mounts, nil
+}
+
+func collectMounts(spec map[string]interface{}) ([]MountInfo, error) {
+	t, ok := spec["type"].(string)
+	if !ok {
+		return nil, errors.New("unexpected spec type")
+	}
+
+	switch t {
+	case "mount":
+		mounts, ok := spec["mounts"].([]interface{})
+		if !ok {
+			return nil, errors.New("unexpected mounts type")
+		}
+
+		var out []MountInfo
+		for _, m := range mounts {
+			mount, ok := m.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("unexpected mount type")
+			}
+
+			sub, err := collectMounts(mount)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, sub...)
+		}
+		return out, nil
+	case "measure":
+		child, ok := spec["child"].(map[string]interface{})
+		if !ok {
+			return nil, errors.New("unexpected child type")
+		}
+		return collectMounts(child)
+	case "badgerds", "flatfs", "levelds":
+		path, ok := spec["path"].(string)
+		if !ok {
+			return nil, errors.New("unexpected path type")
+		}
+		return []MountInfo{{Type: t, Path: path}}, nil
+	default:
+		return nil, errors.New("unexpected ds type")
+	}
+}
+
+// DSStat summarizes a datastore's on-disk contents.
+type DSStat struct {
+	Path    string
+	Version string // empty for mounts with no Stepper (flatfs, levelds)
+	Entries int64
+	Bytes   int64
+}
+
+// Entry is the exported form of keyValue, for callers outside this
+// package (e.g. cmd/badgerds-inspect) that can't see keyValue's fields.
+type Entry struct {
+	Key       []byte
+	Value     []byte
+	Meta      byte
+	ExpiresAt uint64
+}
+
+// ReadOnlyDS is a read-only view over a single badgerds datastore, built
+// on the same Stepper used for migration so inspection reuses its
+// version-detection and decoding logic instead of duplicating it.
+type ReadOnlyDS interface {
+	Get(key []byte) ([]byte, bool, error)
+	List(prefix []byte) (<-chan Entry, error)
+	Stat() (DSStat, error)
+	Close() error
+}
+
+// Open detects path's badger format and returns a read-only view over it,
+// for list/get/stat-style inspection without running a full upgrade.
+func Open(path string) (ReadOnlyDS, error) {
+	idx, err := detectStepper(path)
+	if err != nil {
+		return nil, err
+	}
+	return &stepperDS{path: path, stepper: steppers[idx]}, nil
+}
+
+type stepperDS struct {
+	path    string
+	stepper Stepper
+}
+
+// Get linear-scans the datastore for key. This is an inspection tool, not
+// a hot path, so a full Export per call is an acceptable trade for not
+// needing a per-version point-lookup method on Stepper.
+func (d *stepperDS) Get(key []byte) ([]byte, bool, error) {
+	data, err := d.stepper.Export(context.Background(), d.path, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for kv := range data {
+		if bytes.Equal(kv.key, key) {
+			go func() {
+				for range data {
+				}
+			}()
+			return kv.value, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func (d *stepperDS) List(prefix []byte) (<-chan Entry, error) {
+	data, err := d.stepper.Export(context.Background(), d.path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan Entry)
+	go func() {
+		defer close(out)
+		for kv := range data {
+			if len(prefix) > 0 && !bytes.HasPrefix(kv.key, prefix) {
+				continue
+			}
+			out <- Entry{Key: kv.key, Value: kv.value, Meta: kv.meta, ExpiresAt: kv.expiresAt}
+		}
+	}()
+	return out, nil
+}
+
+func (d *stepperDS) Stat() (DSStat, error) {
+	data, err := d.stepper.Export(context.Background(), d.path, nil)
+	if err != nil {
+		return DSStat{}, err
+	}
+
+	stat := DSStat{Path: d.path, Version: d.stepper.Version()}
+	for kv := range data {
+		stat.Entries++
+		stat.Bytes += int64(len(kv.key) + len(kv.value))
+	}
+	return stat, nil
+}
+
+func (d *stepperDS) Close() error { return nil }
+
+// DirSize sums the on-disk size of every regular file under path. It is
+// used to report a size for flatfs/levelds mounts, which have no Stepper
+// and so no structured Stat.
+func DirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size, err
+}
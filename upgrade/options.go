@@ -0,0 +1,165 @@
+package upgrade
+
+import "context"
+
+// config holds every knob Upgrade's behavior depends on. It is built per
+// call from Options rather than kept in package-level vars, so two
+// concurrent Upgrade calls in the same process never share settings.
+type config struct {
+	targetVersion      string
+	exportTo           string
+	resume             bool
+	restart            bool
+	workers            int
+	batchBytes         int64
+	syncWrites         bool
+	verifyAfterUpgrade bool
+}
+
+func defaultConfig() config {
+	return config{
+		workers:    4,
+		batchBytes: 64 << 20,
+		syncWrites: true,
+	}
+}
+
+// Option configures a single Upgrade or Verify call.
+type Option func(*config)
+
+// WithTargetVersion selects the Stepper version to upgrade to. The zero
+// value upgrades to the newest registered Stepper.
+func WithTargetVersion(version string) Option {
+	return func(c *config) { c.targetVersion = version }
+}
+
+// WithExportTo streams the final hop into the Sink named by dest
+// (<format>:<path>, e.g. "car:/tmp/out.car") instead of replacing the
+// datastore in place.
+func WithExportTo(dest string) Option {
+	return func(c *config) { c.exportTo = dest }
+}
+
+// WithResume picks an in-flight migration back up from its last
+// checkpoint, if one exists.
+func WithResume(resume bool) Option {
+	return func(c *config) { c.resume = resume }
+}
+
+// WithRestart discards any existing checkpoint and stale temp dirs and
+// starts the migration over from scratch.
+func WithRestart(restart bool) Option {
+	return func(c *config) { c.restart = restart }
+}
+
+// WithWorkers sets how many concurrent reader/writer goroutines a Stepper
+// hop uses.
+func WithWorkers(workers int) Option {
+	return func(c *config) { c.workers = workers }
+}
+
+// WithBatchBytes sets the cumulative (key+value) byte size a writer
+// worker accumulates before committing its transaction. Only hops into
+// badger 1 (badger10Stepper) use it; the badger v2-v4 steppers write
+// through a badger.WriteBatch, which flushes on its own schedule and
+// ignores this setting.
+func WithBatchBytes(batchBytes int64) Option {
+	return func(c *config) { c.batchBytes = batchBytes }
+}
+
+// WithSyncWrites controls whether writer transactions fsync on every
+// commit. Migrations that can tolerate re-running on crash may turn this
+// off for speed.
+func WithSyncWrites(sync bool) Option {
+	return func(c *config) { c.syncWrites = sync }
+}
+
+// WithVerifyAfterUpgrade runs a post-migration verification pass for
+// every datastore touched, comparing the pre-migration backup against the
+// migrated copy before writing upgrade-manifest.json.
+func WithVerifyAfterUpgrade(verify bool) Option {
+	return func(c *config) { c.verifyAfterUpgrade = verify }
+}
+
+// workerCtxKey is the type of the context keys Upgrade uses to pass
+// worker/batch/sync settings down to Stepper.Export and Stepper.Import,
+// whose signatures are fixed by the Stepper interface and so can't take a
+// *config parameter directly.
+type workerCtxKey int
+
+const (
+	workersCtxKey workerCtxKey = iota
+	batchBytesCtxKey
+	syncWritesCtxKey
+	exportWorkersCtxKey
+	commitCallbackCtxKey
+)
+
+func withStepperConfig(ctx context.Context, c config) context.Context {
+	ctx = context.WithValue(ctx, workersCtxKey, c.workers)
+	ctx = context.WithValue(ctx, batchBytesCtxKey, c.batchBytes)
+	ctx = context.WithValue(ctx, syncWritesCtxKey, c.syncWrites)
+	ctx = context.WithValue(ctx, exportWorkersCtxKey, c.workers)
+	return ctx
+}
+
+func workersFromContext(ctx context.Context) int {
+	if v, ok := ctx.Value(workersCtxKey).(int); ok && v > 0 {
+		return v
+	}
+	return 1
+}
+
+func batchBytesFromContext(ctx context.Context) int64 {
+	if v, ok := ctx.Value(batchBytesCtxKey).(int64); ok && v > 0 {
+		return v
+	}
+	return 64 << 20
+}
+
+func syncWritesFromContext(ctx context.Context) bool {
+	v, _ := ctx.Value(syncWritesCtxKey).(bool)
+	return v
+}
+
+// exportWorkersFromContext returns the number of concurrent partitions a
+// Stepper.Export should read with. It is tracked separately from
+// workersCtxKey (which sizes the writer pool on the Import side) because
+// upgradeDs forces this down to 1 for a checkpointed hop: a checkpoint's
+// single LastKey only means "safe to resume after" if entries were
+// produced in strict key order, which a partitioned, multi-goroutine
+// Export cannot guarantee (see checkpointTracker).
+func exportWorkersFromContext(ctx context.Context) int {
+	if v, ok := ctx.Value(exportWorkersCtxKey).(int); ok && v > 0 {
+		return v
+	}
+	return 1
+}
+
+// withSequentialExport forces exportWorkersFromContext to 1, for callers
+// that need Export's output in strict key order.
+func withSequentialExport(ctx context.Context) context.Context {
+	return context.WithValue(ctx, exportWorkersCtxKey, 1)
+}
+
+// commitFunc is invoked by a Stepper.Import implementation every time it
+// durably commits a batch, reporting which writer worker committed
+// (workerIdx, stable for the lifetime of one Import call), the largest
+// key in that batch, and how many entries it contained. It lets a caller
+// like checkpointTracker track a true low-water mark across several
+// concurrent writers without Import needing to know anything about
+// checkpointing.
+type commitFunc func(workerIdx int, key []byte, count int)
+
+func noopCommit(int, []byte, int) {}
+
+func withCommitCallback(ctx context.Context, fn commitFunc) context.Context {
+	return context.WithValue(ctx, commitCallbackCtxKey, fn)
+}
+
+func commitCallbackFromContext(ctx context.Context) commitFunc {
+	if fn, ok := ctx.Value(commitCallbackCtxKey).(commitFunc); ok && fn != nil {
+		return fn
+	}
+	return noopCommit
+}
@@ -0,0 +1,37 @@
+package upgrade
+
+import "fmt"
+
+// Sink receives the (key, value) pairs produced by a Stepper's Export and
+// durably stores them somewhere. badgerSink is the default: it writes into
+// a fresh badger datastore that later replaces the original in place.
+// carSink and tarSink instead stream the data out to a portable archive
+// that can be moved to another machine or ingested by a different
+// datastore implementation entirely.
+type Sink interface {
+	Put(kv keyValue) error
+	Close() error
+}
+
+// parseExportFlag splits a --export=<format>:<path> value into its format
+// and path parts.
+func parseExportFlag(flag string) (format, path string, err error) {
+	for i := 0; i < len(flag); i++ {
+		if flag[i] == ':' {
+			return flag[:i], flag[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("invalid --export value %q, expected <format>:<path>", flag)
+}
+
+// newSink builds the Sink named by format, rooted at path.
+func newSink(format, path string) (Sink, error) {
+	switch format {
+	case "car":
+		return newCarSink(path)
+	case "tar":
+		return newTarSink(path)
+	default:
+		return nil, fmt.Errorf("unknown export format %q, expected \"car\" or \"tar\"", format)
+	}
+}
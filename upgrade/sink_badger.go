@@ -0,0 +1,49 @@
+package upgrade
+
+import (
+	"context"
+	"io"
+)
+
+// badgerSink adapts a Stepper's batch-oriented Import to the per-entry
+// Sink interface, by feeding Put calls into the channel Import itself
+// reads from on a background goroutine. It is the default Sink used by
+// upgradeDs for the in-place upgrade path.
+type badgerSink struct {
+	data chan keyValue
+	done chan error
+}
+
+func newBadgerSink(ctx context.Context, dst Stepper, path string) *badgerSink {
+	data := make(chan keyValue)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- dst.Import(ctx, path, data)
+	}()
+
+	return &badgerSink{data: data, done: done}
+}
+
+// Put sends kv to the Import goroutine, but also selects on done so that
+// an importer that has already exited (dst.Import failing before it
+// starts draining data, e.g. badger.Open failing on a full disk) reports
+// that error instead of leaving Put blocked forever on a send nobody
+// will ever receive.
+func (s *badgerSink) Put(kv keyValue) error {
+	select {
+	case s.data <- kv:
+		return nil
+	case err := <-s.done:
+		s.done <- err // Close still needs to observe it.
+		if err == nil {
+			err = io.ErrClosedPipe
+		}
+		return err
+	}
+}
+
+func (s *badgerSink) Close() error {
+	close(s.data)
+	return <-s.done
+}
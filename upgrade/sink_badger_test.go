@@ -0,0 +1,62 @@
+package upgrade
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// failStepper's Import returns immediately without reading from data, to
+// simulate a destination that fails before it starts draining (badger.Open
+// hitting a full disk, a bad path, etc).
+type failStepper struct{ err error }
+
+func (failStepper) Version() string            { return "fail" }
+func (failStepper) Detect(string) (bool, error) { return false, nil }
+
+func (failStepper) Export(context.Context, string, []byte) (<-chan keyValue, error) {
+	return nil, nil
+}
+
+func (s failStepper) Import(context.Context, string, <-chan keyValue) error { return s.err }
+
+func TestBadgerSinkPutReportsDeadImporter(t *testing.T) {
+	wantErr := errors.New("boom")
+	sink := newBadgerSink(context.Background(), failStepper{err: wantErr}, "/dev/null")
+
+	done := make(chan error, 1)
+	go func() { done <- sink.Put(keyValue{key: []byte("k"), value: []byte("v")}) }()
+
+	select {
+	case err := <-done:
+		if err != wantErr {
+			t.Fatalf("Put error = %v, want %v", err, wantErr)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Put blocked forever instead of reporting the dead importer")
+	}
+
+	// Close must still be able to observe the same error afterwards.
+	if err := sink.Close(); err != wantErr {
+		t.Fatalf("Close error = %v, want %v", err, wantErr)
+	}
+}
+
+func TestBadgerSinkPutSucceedsWhileImporterAlive(t *testing.T) {
+	data := make(chan keyValue, 1)
+	done := make(chan error, 1)
+	sink := &badgerSink{data: data, done: done}
+
+	if err := sink.Put(keyValue{key: []byte("k")}); err != nil {
+		t.Fatalf("Put error = %v, want nil", err)
+	}
+	select {
+	case got := <-data:
+		if string(got.key) != "k" {
+			t.Fatalf("got key %q, want %q", got.key, "k")
+		}
+	default:
+		t.Fatal("Put did not forward the entry to data")
+	}
+}
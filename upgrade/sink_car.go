@@ -0,0 +1,78 @@
+package upgrade
+
+import (
+	"bufio"
+	"io"
+	"os"
+
+	cid "github.com/ipfs/go-cid"
+	varint "github.com/multiformats/go-varint"
+)
+
+// carSink streams exported entries into a CARv1 file. Only keys that
+// decode as CIDs are written as blocks; everything else is dropped, since
+// a CAR has no way to address a non-content-addressed key.
+type carSink struct {
+	f *os.File
+	w *bufio.Writer
+
+	dropped int
+}
+
+func newCarSink(path string) (Sink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(f)
+	if err := writeCarHeader(w); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &carSink{f: f, w: w}, nil
+}
+
+// writeCarHeader writes a rootless CARv1 header, since a raw key/value
+// export has no DAG root to point at.
+func writeCarHeader(w io.Writer) error {
+	header := []byte{
+		0xa2, // map(2)
+		0x65, 'r', 'o', 'o', 't', 's',
+		0x80, // array(0)
+		0x67, 'v', 'e', 'r', 's', 'i', 'o', 'n',
+		0x01,
+	}
+	return writeVarintFrame(w, header)
+}
+
+func writeVarintFrame(w io.Writer, b []byte) error {
+	if _, err := w.Write(varint.ToUvarint(uint64(len(b)))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func (s *carSink) Put(kv keyValue) error {
+	c, err := cid.Parse(kv.key)
+	if err != nil {
+		s.dropped++
+		return nil
+	}
+
+	frame := append(c.Bytes(), kv.value...)
+	return writeVarintFrame(s.w, frame)
+}
+
+func (s *carSink) Close() error {
+	if s.dropped > 0 {
+		Log.Printf("Dropped %d non-CID keys while writing CAR\n", s.dropped)
+	}
+	if err := s.w.Flush(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
@@ -0,0 +1,80 @@
+package upgrade
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+	varint "github.com/multiformats/go-varint"
+)
+
+func TestCarSinkWritesBlocksAndDropsNonCIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.car")
+
+	sink, err := newCarSink(path)
+	if err != nil {
+		t.Fatalf("newCarSink: %v", err)
+	}
+
+	sum, err := mh.Sum([]byte("hello"), mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatalf("mh.Sum: %v", err)
+	}
+	c := cid.NewCidV1(cid.Raw, sum)
+
+	if err := sink.Put(keyValue{key: c.Bytes(), value: []byte("hello")}); err != nil {
+		t.Fatalf("Put(cid): %v", err)
+	}
+	if err := sink.Put(keyValue{key: []byte("not-a-cid"), value: []byte("ignored")}); err != nil {
+		t.Fatalf("Put(non-cid): %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open output: %v", err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	// Header frame.
+	if _, err := readCarFrame(r); err != nil {
+		t.Fatalf("reading header frame: %v", err)
+	}
+
+	block, err := readCarFrame(r)
+	if err != nil {
+		t.Fatalf("reading block frame: %v", err)
+	}
+
+	n, gotCid, err := cid.CidFromBytes(block)
+	if err != nil {
+		t.Fatalf("CidFromBytes: %v", err)
+	}
+	if !gotCid.Equals(c) {
+		t.Fatalf("got cid %s, want %s", gotCid, c)
+	}
+	if got, want := string(block[n:]), "hello"; got != want {
+		t.Fatalf("got value %q, want %q", got, want)
+	}
+
+	if _, err := readCarFrame(r); err != io.EOF {
+		t.Fatalf("expected exactly one block frame, got extra data (err=%v)", err)
+	}
+}
+
+func readCarFrame(r *bufio.Reader) ([]byte, error) {
+	size, err := varint.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	_, err = io.ReadFull(r, buf)
+	return buf, err
+}
@@ -0,0 +1,50 @@
+package upgrade
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+)
+
+// tarSink streams exported entries into a plain tar archive as
+// "key\0value" records, one entry per file, for datastores whose keys
+// are not CIDs and so cannot go into a carSink.
+type tarSink struct {
+	f  *os.File
+	tw *tar.Writer
+}
+
+func newTarSink(path string) (Sink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tarSink{f: f, tw: tar.NewWriter(f)}, nil
+}
+
+func (s *tarSink) Put(kv keyValue) error {
+	record := make([]byte, 0, len(kv.key)+1+len(kv.value))
+	record = append(record, kv.key...)
+	record = append(record, 0)
+	record = append(record, kv.value...)
+
+	hdr := &tar.Header{
+		Name: fmt.Sprintf("%x", kv.key),
+		Mode: 0644,
+		Size: int64(len(record)),
+	}
+	if err := s.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := s.tw.Write(record)
+	return err
+}
+
+func (s *tarSink) Close() error {
+	if err := s.tw.Close(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
@@ -0,0 +1,64 @@
+package upgrade
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTarSinkWritesKeyValueRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.tar")
+
+	sink, err := newTarSink(path)
+	if err != nil {
+		t.Fatalf("newTarSink: %v", err)
+	}
+
+	want := []keyValue{
+		{key: []byte("foo"), value: []byte("bar")},
+		{key: []byte{0x00, 0x01}, value: []byte("binary value")},
+	}
+	for _, kv := range want {
+		if err := sink.Put(kv); err != nil {
+			t.Fatalf("Put(%x): %v", kv.key, err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open output: %v", err)
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for _, kv := range want {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("tr.Next: %v", err)
+		}
+		if got, want := hdr.Name, fmt.Sprintf("%x", kv.key); got != want {
+			t.Fatalf("entry name = %q, want %q", got, want)
+		}
+
+		record, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("reading record: %v", err)
+		}
+		want := append(append(append([]byte(nil), kv.key...), 0), kv.value...)
+		if !bytes.Equal(record, want) {
+			t.Fatalf("record = %x, want %x", record, want)
+		}
+	}
+
+	if _, err := tr.Next(); err != io.EOF {
+		t.Fatalf("expected exactly %d entries, got extra data (err=%v)", len(want), err)
+	}
+}
@@ -0,0 +1,66 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+)
+
+// Stepper knows how to detect, read and write a single on-disk badger
+// format. A chain of Steppers, ordered oldest to newest, lets Upgrade
+// walk from whatever format is found on disk up to a requested target
+// version one hop at a time.
+type Stepper interface {
+	// Version identifies the badger format this Stepper handles, e.g. "0.8", "1", "2".
+	Version() string
+
+	// Detect reports whether path contains a datastore in this Stepper's format.
+	Detect(path string) (bool, error)
+
+	// Export streams every (key, value) pair out of path on channel, closing
+	// it when done or when ctx is cancelled. If after is non-nil, iteration
+	// seeks past it first, so a resumed migration does not re-export
+	// entries an earlier, interrupted run already committed.
+	Export(ctx context.Context, path string, after []byte) (<-chan keyValue, error)
+
+	// Import writes every entry read from data into a fresh datastore at path.
+	Import(ctx context.Context, path string, data <-chan keyValue) error
+}
+
+// steppers holds every registered Stepper, ordered oldest to newest. The
+// order matters: it is both the detection order (newest first) and the
+// walk order (oldest to newest) used by upgradeDs.
+var steppers []Stepper
+
+// RegisterStepper adds a Stepper to the chain. It is meant to be called
+// from package init funcs, oldest version first.
+func RegisterStepper(s Stepper) {
+	steppers = append(steppers, s)
+}
+
+// detectStepper returns the index into steppers of the first Stepper that
+// recognises path, searching newest to oldest so that a datastore that
+// happens to satisfy an older format's Detect too (rare, but badger's
+// manifest checks are loose) still resolves to its real version.
+func detectStepper(path string) (int, error) {
+	for i := len(steppers) - 1; i >= 0; i-- {
+		ok, err := steppers[i].Detect(path)
+		if err != nil {
+			return -1, err
+		}
+		if ok {
+			return i, nil
+		}
+	}
+	return -1, ErrInvalidVersion
+}
+
+// targetStepperIndex resolves the --target-version flag value to an index
+// into steppers.
+func targetStepperIndex(version string) (int, error) {
+	for i, s := range steppers {
+		if s.Version() == version {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("unknown target version %q", version)
+}
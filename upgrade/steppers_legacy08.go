@@ -0,0 +1,173 @@
+//go:build legacy08
+
+package upgrade
+
+// badger08Stepper handles the pre-1.0 badger KV API originally shipped by
+// go-ipfs fsrepo version 6. That API predates badger's first published Go
+// module and was only ever distributed as a gx-addressed GOPATH package
+// (the gx/ipfs/... import below), which no module proxy can resolve and
+// which this checkout does not carry a vendored copy of. Building this
+// file therefore requires both the "legacy08" build tag and a local
+// vendor/replace entry pointing the gx import at a real source tree; ask
+// in #dms3-fs if you need to migrate an actual 0.8 repo and don't have
+// one handy. Every other stepper in this package builds without either.
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+
+	badger08 "gx/ipfs/QmaYHhxyszcAYob7WP8nSXnkJjzwfsWyApZEJFaJoJnXNP/badger"
+)
+
+func init() {
+	RegisterStepper(badger08Stepper{})
+}
+
+type badger08Stepper struct{}
+
+func (badger08Stepper) Version() string { return "0.8" }
+
+func (badger08Stepper) Detect(path string) (bool, error) {
+	opt := badger08.DefaultOptions
+	opt.Dir = path
+	opt.ValueDir = path
+
+	kv, err := badger08.NewKV(&opt)
+	if err != nil {
+		if isVersionMismatch(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	kv.Close()
+	return true, nil
+}
+
+// Export reads path with ctx's configured number of concurrent iterators
+// (see exportWorkersFromContext), each owning a disjoint first-byte range
+// of the keyspace, so a large 0.8 repo isn't bottlenecked on a single
+// iterator. Resuming after a specific key (see WithResume) can't be
+// split this way, so that case falls back to a single sequential
+// iterator seeked past after. A checkpointed hop (upgradeDs's in-place
+// path) also forces exportWorkersFromContext down to 1 even on a fresh,
+// non-resumed run, since a partitioned export delivers entries out of
+// key order, which would make checkpointTracker's single LastKey
+// meaningless.
+func (badger08Stepper) Export(ctx context.Context, path string, after []byte) (<-chan keyValue, error) {
+	opt := badger08.DefaultOptions
+	opt.Dir = path
+	opt.ValueDir = path
+	opt.SyncWrites = true
+
+	kv, err := badger08.NewKV(&opt)
+	if err != nil {
+		if isVersionMismatch(err) {
+			return nil, ErrInvalidVersion
+		}
+		return nil, err
+	}
+
+	out := make(chan keyValue)
+
+	if len(after) > 0 {
+		go func() {
+			defer kv.Close()
+			defer close(out)
+			exportBadger08Sequential(ctx, kv, after, out)
+		}()
+		return out, nil
+	}
+
+	workers := exportWorkersFromContext(ctx)
+
+	go func() {
+		defer kv.Close()
+
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			lo, hi := keyByteRange(w, workers)
+			wg.Add(1)
+			go func(lo, hi []byte) {
+				defer wg.Done()
+				exportBadger08Range(ctx, kv, lo, hi, out)
+			}(lo, hi)
+		}
+		wg.Wait()
+		close(out)
+	}()
+
+	return out, nil
+}
+
+// keyByteRange splits the keyspace into n disjoint ranges by first byte,
+// returning the [lo, hi) range owned by worker i. hi is nil for the last
+// worker, meaning "to the end".
+func keyByteRange(i, n int) (lo, hi []byte) {
+	lo = []byte{byte(i * 256 / n)}
+	if i == n-1 {
+		return lo, nil
+	}
+	return lo, []byte{byte((i + 1) * 256 / n)}
+}
+
+func exportBadger08Range(ctx context.Context, kv *badger08.KV, lo, hi []byte, out chan<- keyValue) {
+	it := kv.NewIterator(badger08.DefaultIteratorOptions)
+	defer it.Close()
+
+	for it.Seek(lo); it.Valid(); it.Next() {
+		item := it.Item()
+		if hi != nil && bytes.Compare(item.Key(), hi) >= 0 {
+			return
+		}
+
+		err := item.Value(func(data []byte) error {
+			select {
+			case out <- keyValue{key: item.Key(), value: data, meta: item.UserMeta(), expiresAt: item.ExpiresAt()}:
+			case <-ctx.Done():
+				return ErrCancelled
+			}
+			return nil
+		})
+		if err == ErrCancelled {
+			return
+		}
+		if err != nil {
+			Log.Printf("Error: %s\n", err.Error())
+			return
+		}
+	}
+}
+
+func exportBadger08Sequential(ctx context.Context, kv *badger08.KV, after []byte, out chan<- keyValue) {
+	it := kv.NewIterator(badger08.DefaultIteratorOptions)
+	defer it.Close()
+
+	it.Seek(after)
+	if it.Valid() && bytes.Equal(it.Item().Key(), after) {
+		it.Next()
+	}
+
+	for ; it.Valid(); it.Next() {
+		item := it.Item()
+		err := item.Value(func(data []byte) error {
+			select {
+			case out <- keyValue{key: item.Key(), value: data, meta: item.UserMeta(), expiresAt: item.ExpiresAt()}:
+			case <-ctx.Done():
+				return ErrCancelled
+			}
+			return nil
+		})
+		if err == ErrCancelled {
+			return
+		}
+		if err != nil {
+			Log.Printf("Error: %s\n", err.Error())
+			return
+		}
+	}
+}
+
+func (badger08Stepper) Import(ctx context.Context, path string, data <-chan keyValue) error {
+	return fmt.Errorf("importing into badger 0.8 datastores is not supported")
+}
@@ -0,0 +1,223 @@
+package upgrade
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	badger10 "github.com/dgraph-io/badger"
+)
+
+func init() {
+	RegisterStepper(badger10Stepper{})
+}
+
+// badger10Stepper handles the badger 1.0 format introduced by go-ipfs to
+// replace 0.8. It imports the real github.com/dgraph-io/badger module
+// (the last release published under that unversioned path, before v2
+// adopted semantic import versioning) rather than the gx-addressed
+// snapshot this package used to carry, since gx paths aren't resolvable
+// under Go modules. badger 0.8 support (badger08Stepper) has no such
+// module to fall back to and lives behind the "legacy08" build tag in
+// steppers_legacy08.go instead.
+type badger10Stepper struct{}
+
+func (badger10Stepper) Version() string { return "1" }
+
+func (badger10Stepper) Detect(path string) (bool, error) {
+	opt := badger10.DefaultOptions(path)
+
+	db, err := badger10.Open(opt)
+	if err != nil {
+		if isVersionMismatch(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	db.Close()
+	return true, nil
+}
+
+func (badger10Stepper) Export(ctx context.Context, path string, after []byte) (<-chan keyValue, error) {
+	opt := badger10.DefaultOptions(path)
+
+	db, err := badger10.Open(opt)
+	if err != nil {
+		if isVersionMismatch(err) {
+			return nil, ErrInvalidVersion
+		}
+		return nil, err
+	}
+
+	out := make(chan keyValue)
+	go func() {
+		defer db.Close()
+		defer close(out)
+
+		txn := db.NewTransaction(false)
+		defer txn.Discard()
+
+		it := txn.NewIterator(badger10.DefaultIteratorOptions)
+		defer it.Close()
+
+		if len(after) > 0 {
+			it.Seek(after)
+			if it.Valid() && bytes.Equal(it.Item().KeyCopy(nil), after) {
+				it.Next()
+			}
+		} else {
+			it.Rewind()
+		}
+
+		for ; it.Valid(); it.Next() {
+			item := it.Item()
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				Log.Printf("Error: %s\n", err.Error())
+				return
+			}
+			select {
+			case out <- keyValue{key: item.KeyCopy(nil), value: value, meta: item.UserMeta(), expiresAt: item.ExpiresAt()}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// Import writes data into path with a pool of writer goroutines (sized by
+// ctx's configured worker count), each holding its own transaction that it
+// commits once it has accumulated ctx's configured batch size worth of
+// entries or a second has passed, instead of the single unbounded
+// transaction this used to be. This badger pin predates the WriteBatch
+// type newer steppers use (see badger2Stepper), so the batching here is
+// done by hand, with each worker reporting its own commits through ctx's
+// commitFunc (see commitCallbackFromContext) so a caller like
+// checkpointTracker can tell what's actually durable.
+func (badger10Stepper) Import(ctx context.Context, path string, data <-chan keyValue) error {
+	opt := badger10.DefaultOptions(path)
+	opt.SyncWrites = syncWritesFromContext(ctx)
+
+	db, err := badger10.Open(opt)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	Log.Printf("Moving data to %s\n", path)
+
+	workers := workersFromContext(ctx)
+	batchBytes := batchBytesFromContext(ctx)
+	onCommit := commitCallbackFromContext(ctx)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	var n int64
+
+	for w := 0; w < workers; w++ {
+		w := w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := importBadger10Worker(ctx, db, data, &n, batchBytes, w, onCommit); err != nil {
+				errs <- err
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	Log.Printf("%d entries done\n", n)
+	return nil
+}
+
+func importBadger10Worker(ctx context.Context, db *badger10.DB, data <-chan keyValue, n *int64, maxBatchBytes int64, workerIdx int, onCommit commitFunc) error {
+	txn := db.NewTransaction(true)
+	var batchBytes int64
+	var batchCount int
+	var lastKey []byte
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	commit := func() error {
+		if err := txn.Commit(); err != nil {
+			return err
+		}
+		if batchCount > 0 {
+			onCommit(workerIdx, lastKey, batchCount)
+		}
+		txn = db.NewTransaction(true)
+		batchBytes = 0
+		batchCount = 0
+		return nil
+	}
+
+	for entry := range data {
+		select {
+		case <-ctx.Done():
+			txn.Discard()
+			return ErrCancelled
+		default:
+		}
+
+		e := badger10.NewEntry(entry.key, entry.value).WithMeta(entry.meta)
+		if entry.expiresAt > 0 {
+			if ttl := time.Until(time.Unix(int64(entry.expiresAt), 0)); ttl > 0 {
+				e = e.WithTTL(ttl)
+			}
+		}
+
+		if err := txn.SetEntry(e); err == badger10.ErrTxnTooBig {
+			if err := commit(); err != nil {
+				return err
+			}
+			if err := txn.SetEntry(e); err != nil {
+				txn.Discard()
+				return err
+			}
+		} else if err != nil {
+			txn.Discard()
+			return err
+		}
+
+		batchBytes += int64(len(entry.key) + len(entry.value))
+		batchCount++
+		lastKey = entry.key
+		done := atomic.AddInt64(n, 1)
+		if done%1000 == 0 {
+			Log.Printf("%d entries done\r\x1b[A", done)
+		}
+
+		select {
+		case <-ticker.C:
+			if err := commit(); err != nil {
+				return err
+			}
+		default:
+			if batchBytes >= maxBatchBytes {
+				if err := commit(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+	if batchCount > 0 {
+		onCommit(workerIdx, lastKey, batchCount)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package upgrade
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	badger2 "github.com/dgraph-io/badger/v2"
+)
+
+func init() {
+	RegisterStepper(badger2Stepper{})
+}
+
+// badger2Stepper handles the badger v2 format.
+type badger2Stepper struct{}
+
+func (badger2Stepper) Version() string { return "2" }
+
+func (badger2Stepper) Detect(path string) (bool, error) {
+	opt := badger2.DefaultOptions(path).WithSyncWrites(false)
+
+	db, err := badger2.Open(opt)
+	if err != nil {
+		if isVersionMismatch(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	db.Close()
+	return true, nil
+}
+
+func (badger2Stepper) Export(ctx context.Context, path string, after []byte) (<-chan keyValue, error) {
+	opt := badger2.DefaultOptions(path).WithSyncWrites(false)
+
+	db, err := badger2.Open(opt)
+	if err != nil {
+		if isVersionMismatch(err) {
+			return nil, ErrInvalidVersion
+		}
+		return nil, err
+	}
+
+	out := make(chan keyValue)
+	go func() {
+		defer db.Close()
+		defer close(out)
+
+		txn := db.NewTransaction(false)
+		defer txn.Discard()
+
+		it := txn.NewIterator(badger2.DefaultIteratorOptions)
+		defer it.Close()
+
+		if len(after) > 0 {
+			it.Seek(after)
+			if it.Valid() && bytes.Equal(it.Item().KeyCopy(nil), after) {
+				it.Next()
+			}
+		} else {
+			it.Rewind()
+		}
+
+		for ; it.Valid(); it.Next() {
+			item := it.Item()
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				Log.Printf("Error: %s\n", err.Error())
+				return
+			}
+			select {
+			case out <- keyValue{key: item.KeyCopy(nil), value: value, meta: item.UserMeta(), expiresAt: item.ExpiresAt()}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// Import drains data into a single badger.WriteBatch shared by ctx's
+// configured number of goroutines, which batches and flushes writes
+// internally instead of the single unbounded transaction this used to be.
+// WriteBatch doesn't expose a hook for when it actually flushes a given
+// entry, so unlike badger10Stepper this never calls ctx's commitFunc: a
+// checkpointed hop through this stepper can't resume partway through,
+// only at the start (same as before this hop ran) or once the whole hop
+// has completed.
+func (badger2Stepper) Import(ctx context.Context, path string, data <-chan keyValue) error {
+	opt := badger2.DefaultOptions(path).WithSyncWrites(syncWritesFromContext(ctx))
+
+	db, err := badger2.Open(opt)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	Log.Printf("Moving data to %s\n", path)
+
+	wb := db.NewWriteBatch()
+	defer wb.Cancel()
+
+	workers := workersFromContext(ctx)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	var n int64
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range data {
+				select {
+				case <-ctx.Done():
+					errs <- ErrCancelled
+					return
+				default:
+				}
+
+				e := badger2.NewEntry(entry.key, entry.value).WithMeta(entry.meta)
+				if entry.expiresAt > 0 {
+					if ttl := time.Until(time.Unix(int64(entry.expiresAt), 0)); ttl > 0 {
+						e = e.WithTTL(ttl)
+					}
+				}
+
+				if err := wb.SetEntry(e); err != nil {
+					errs <- err
+					return
+				}
+
+				if done := atomic.AddInt64(&n, 1); done%1000 == 0 {
+					Log.Printf("%d entries done\r\x1b[A", done)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	Log.Printf("%d entries done\n", n)
+	return wb.Flush()
+}
@@ -0,0 +1,150 @@
+package upgrade
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	badger3 "github.com/dgraph-io/badger/v3"
+)
+
+func init() {
+	RegisterStepper(badger3Stepper{})
+}
+
+// badger3Stepper handles the badger v3 format.
+type badger3Stepper struct{}
+
+func (badger3Stepper) Version() string { return "3" }
+
+func (badger3Stepper) Detect(path string) (bool, error) {
+	opt := badger3.DefaultOptions(path).WithSyncWrites(false)
+
+	db, err := badger3.Open(opt)
+	if err != nil {
+		if isVersionMismatch(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	db.Close()
+	return true, nil
+}
+
+func (badger3Stepper) Export(ctx context.Context, path string, after []byte) (<-chan keyValue, error) {
+	opt := badger3.DefaultOptions(path).WithSyncWrites(false)
+
+	db, err := badger3.Open(opt)
+	if err != nil {
+		if isVersionMismatch(err) {
+			return nil, ErrInvalidVersion
+		}
+		return nil, err
+	}
+
+	out := make(chan keyValue)
+	go func() {
+		defer db.Close()
+		defer close(out)
+
+		txn := db.NewTransaction(false)
+		defer txn.Discard()
+
+		it := txn.NewIterator(badger3.DefaultIteratorOptions)
+		defer it.Close()
+
+		if len(after) > 0 {
+			it.Seek(after)
+			if it.Valid() && bytes.Equal(it.Item().KeyCopy(nil), after) {
+				it.Next()
+			}
+		} else {
+			it.Rewind()
+		}
+
+		for ; it.Valid(); it.Next() {
+			item := it.Item()
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				Log.Printf("Error: %s\n", err.Error())
+				return
+			}
+			select {
+			case out <- keyValue{key: item.KeyCopy(nil), value: value, meta: item.UserMeta(), expiresAt: item.ExpiresAt()}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// Import drains data into a single badger.WriteBatch shared by ctx's
+// configured number of goroutines, which batches and flushes writes
+// internally instead of the single unbounded transaction this used to be.
+// See badger2Stepper.Import for why this never calls ctx's commitFunc.
+func (badger3Stepper) Import(ctx context.Context, path string, data <-chan keyValue) error {
+	opt := badger3.DefaultOptions(path).WithSyncWrites(syncWritesFromContext(ctx))
+
+	db, err := badger3.Open(opt)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	Log.Printf("Moving data to %s\n", path)
+
+	wb := db.NewWriteBatch()
+	defer wb.Cancel()
+
+	workers := workersFromContext(ctx)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	var n int64
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range data {
+				select {
+				case <-ctx.Done():
+					errs <- ErrCancelled
+					return
+				default:
+				}
+
+				e := badger3.NewEntry(entry.key, entry.value).WithMeta(entry.meta)
+				if entry.expiresAt > 0 {
+					if ttl := time.Until(time.Unix(int64(entry.expiresAt), 0)); ttl > 0 {
+						e = e.WithTTL(ttl)
+					}
+				}
+
+				if err := wb.SetEntry(e); err != nil {
+					errs <- err
+					return
+				}
+
+				if done := atomic.AddInt64(&n, 1); done%1000 == 0 {
+					Log.Printf("%d entries done\r\x1b[A", done)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	Log.Printf("%d entries done\n", n)
+	return wb.Flush()
+}
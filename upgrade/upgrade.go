@@ -2,7 +2,9 @@ package upgrade
 
 import (
 	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	logging "log"
@@ -11,10 +13,6 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
-
-	badger10 "gx/ipfs/QmQBccCGkYxLSdqzvUc6eTDqT9dqPcT7fCHzH6Z4ftWst3/badger"
-	errors "gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
-	badger08 "gx/ipfs/QmaYHhxyszcAYob7WP8nSXnkJjzwfsWyApZEJFaJoJnXNP/badger"
 )
 
 var Log = logging.New(os.Stderr, "upgrade ", logging.LstdFlags)
@@ -29,9 +27,23 @@ const (
 	SuppertedRepoVersion = 6
 )
 
+// isVersionMismatch reports whether err is badger's "wrong manifest
+// version" error, which every badger release so far (0.8 through v4)
+// reports with the same prefix regardless of major version.
+func isVersionMismatch(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "manifest has unsupported version:")
+}
+
 type keyValue struct {
 	key   []byte
 	value []byte
+
+	// meta and expiresAt preserve badger's per-entry user metadata byte and
+	// absolute TTL expiry (unix seconds, 0 if none) across a hop, so they
+	// survive a round trip through Export/Import instead of being silently
+	// dropped.
+	meta      byte
+	expiresAt uint64
 }
 
 type Process struct {
@@ -40,17 +52,37 @@ type Process struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 
+	cfg config
+
 	dbPaths map[string]struct{}
+
+	ckpt     checkpointState
+	manifest manifest
 }
 
-func Upgrade(baseDir string) error {
-	ctx, cancel := context.WithCancel(context.Background())
+// Upgrade walks every badger datastore in baseDir's repo from whatever
+// format is on disk up to the requested target version, one hop at a
+// time. It honors ctx: cancelling it (directly, or via a timeout or a
+// signal handler in the caller) aborts the in-flight hop, leaving its
+// checkpoint in place for a later --resume instead of leaving a half
+// migrated datastore behind.
+func Upgrade(ctx context.Context, baseDir string, opts ...Option) error {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	ctx = withStepperConfig(ctx, cfg)
+
 	p := Process{
 		path: baseDir,
 
 		ctx:    ctx,
 		cancel: cancel,
 
+		cfg: cfg,
+
 		dbPaths: map[string]struct{}{},
 	}
 
@@ -59,162 +91,272 @@ func Upgrade(baseDir string) error {
 		return err
 	}
 
+	if cfg.restart {
+		if err := os.Remove(checkpointPath(p.path)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		p.ckpt = checkpointState{}
+	} else {
+		p.ckpt, err = loadCheckpointState(p.path)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := cleanupStaleTempDirs(p.path, p.ckpt); err != nil {
+		return err
+	}
+
 	paths, err := p.loadSpecs()
 	if err != nil {
 		return err
 	}
 
 	for _, dir := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		err := p.upgradeDs(path.Join(p.path, dir))
 		if err != nil {
 			return err
 		}
 	}
 
+	if cfg.verifyAfterUpgrade && len(p.manifest.Datastores) > 0 {
+		if err := writeManifest(p.path, p.manifest); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// upgradeDs walks the registered Stepper chain from whatever badger format
+// is detected at path up to cfg.targetVersion, one hop at a time. Each hop
+// exports through the source Stepper and imports through the destination
+// Stepper into a fresh temp dir, which then replaces path.
 func (c *Process) upgradeDs(path string) error {
 	Log.Printf("Upgrading badger at %s\n", path)
 
-	Log.Printf("Trying badger 1.0\n")
-	err := c.try10(path)
-	if err == nil || err != ErrInvalidVersion {
-		return err
+	if len(steppers) == 0 {
+		return ErrInvalidVersion
 	}
 
-	Log.Printf("Trying badger 0.8\n")
-	err = c.try08(path)
-	if err == nil || err != ErrInvalidVersion {
+	from, err := detectStepper(path)
+	if err != nil {
 		return err
 	}
 
-	return ErrInvalidVersion
-}
+	to := len(steppers) - 1
+	if c.cfg.targetVersion != "" {
+		to, err = targetStepperIndex(c.cfg.targetVersion)
+		if err != nil {
+			return err
+		}
+	}
 
-func (c *Process) try10(path string) error {
-	opt := badger10.DefaultOptions
-	opt.Dir = path
-	opt.ValueDir = path
-	opt.SyncWrites = true
+	Log.Printf("Detected badger %s, target badger %s\n", steppers[from].Version(), steppers[to].Version())
 
-	db, err := badger10.Open(opt)
-	if err != nil {
-		if strings.HasPrefix(err.Error(), "manifest has unsupported version:") {
-			err = ErrInvalidVersion
-		}
-		return err
+	if from > to {
+		return fmt.Errorf("datastore at %s is already newer (badger %s) than target badger %s", path, steppers[from].Version(), steppers[to].Version())
 	}
 
-	db.Close()
-	return nil
-}
+	if c.cfg.exportTo != "" {
+		return c.exportDs(path, from, to)
+	}
 
-func (c *Process) try08(path string) error {
-	opt := badger08.DefaultOptions
-	opt.Dir = path
-	opt.ValueDir = path
-	opt.SyncWrites = true
+	chain := []string{steppers[from].Version()}
+	var firstBackup string
 
-	kv, err := badger08.NewKV(&opt)
-	if err != nil {
-		if strings.HasPrefix(err.Error(), "manifest has unsupported version:") {
-			err = ErrInvalidVersion
+	for i := from; i < to; i++ {
+		if err := c.ctx.Err(); err != nil {
+			return err
 		}
-		return err
+
+		src, dst := steppers[i], steppers[i+1]
+		Log.Printf("Upgrading badger %s -> %s\n", src.Version(), dst.Version())
+
+		entry, temp, after, err := c.resumeHop(path, i)
+		if err != nil {
+			return err
+		}
+
+		// A checkpointed hop needs src.Export's output in strict key
+		// order (withSequentialExport) and needs to learn progress from
+		// dst.Import's actual commits, not from how far Export has fed
+		// the sink (withCommitCallback) — see checkpointTracker.
+		tracker := newCheckpointTracker(c.path, path, c.ckpt, entry, workersFromContext(c.ctx))
+		hopCtx := withCommitCallback(withSequentialExport(c.ctx), tracker.onCommit)
+
+		data, err := src.Export(hopCtx, path, after)
+		if err != nil {
+			return err
+		}
+
+		sink := &checkpointSink{Sink: newBadgerSink(hopCtx, dst, temp), tracker: tracker}
+		if err := drainInto(sink, data); err != nil {
+			c.cancel()
+			return err
+		}
+		// A cancelled Export closes data early without an error; stop
+		// before the partial temp dir can replace path.
+		if err := c.ctx.Err(); err != nil {
+			return err
+		}
+
+		delete(c.ckpt, path)
+		if err := saveCheckpointState(c.path, c.ckpt); err != nil {
+			return err
+		}
+
+		// swapIn moves the upgraded temp dir back to path, so the next
+		// hop's Export sees path containing dst's format again.
+		backup, err := c.swapIn(path, temp)
+		if err != nil {
+			return err
+		}
+		if firstBackup == "" {
+			firstBackup = backup
+		}
+		chain = append(chain, dst.Version())
 	}
-	out := make(chan keyValue)
-	go func() {
-		defer kv.Close()
-		it := kv.NewIterator(badger08.DefaultIteratorOptions)
-		defer it.Close()
-		for it.Rewind(); it.Valid(); it.Next() {
-			item := it.Item()
-			err := item.Value(func(data []byte) error {
-				select {
-				case out <- keyValue{key: item.Key(), value: data}:
-				case <-c.ctx.Done():
-					return ErrCancelled
-				}
-				return nil
-			})
-			if err == ErrCancelled {
-				return
-			}
-			if err != nil {
-				Log.Printf("Error: %s\n", err.Error())
-				return
-			}
+
+	if c.cfg.verifyAfterUpgrade && firstBackup != "" {
+		dm, err := c.verifyMigration(path, firstBackup, chain)
+		if err != nil {
+			return err
 		}
-		close(out)
-	}()
+		c.manifest.Datastores = append(c.manifest.Datastores, dm)
+	}
 
-	return c.migrateData(out, path)
+	return nil
 }
 
-func (c *Process) migrateData(data chan keyValue, path string) error {
+// resumeHop returns the checkpointEntry, temp dir and seek key to use for
+// hop i of path's migration: either a fresh temp dir and no seek key, or,
+// when --resume finds a matching in-progress checkpoint, the same temp
+// dir and the last key it had committed.
+func (c *Process) resumeHop(path string, step int) (checkpointEntry, string, []byte, error) {
+	if c.cfg.resume {
+		if entry, ok := c.ckpt[path]; ok && entry.Step == step {
+			var after []byte
+			if entry.LastKey != "" {
+				var err error
+				after, err = hex.DecodeString(entry.LastKey)
+				if err != nil {
+					return checkpointEntry{}, "", nil, err
+				}
+			}
+			Log.Printf("Resuming hop %d for %s at entry %d (temp %s)\n", step, path, entry.Count, entry.TempDir)
+			return entry, entry.TempDir, after, nil
+		}
+	}
+
 	temp, err := ioutil.TempDir(c.path, "badger-")
 	if err != nil {
 		c.cancel()
-		return err
+		return checkpointEntry{}, "", nil, err
 	}
+	return checkpointEntry{Step: step, TempDir: temp}, temp, nil, nil
+}
 
-	err = func() error {
-		opt := badger10.DefaultOptions
-		opt.ValueDir = temp
-		opt.Dir = temp
-		opt.SyncWrites = true
-		db, err := badger10.Open(opt)
-		if err != nil {
-			c.cancel()
+// drainInto copies every entry from data into sink and closes it,
+// returning whichever of the Put or Close errors comes first.
+func drainInto(sink Sink, data <-chan keyValue) error {
+	for kv := range data {
+		if err := sink.Put(kv); err != nil {
+			sink.Close()
 			return err
 		}
-		defer db.Close()
+	}
+	return sink.Close()
+}
 
-		txn := db.NewTransaction(true)
-		defer txn.Discard()
+// exportDs walks the Stepper chain the same way as upgradeDs, but instead
+// of materializing the final hop in place it drains it into the Sink
+// named by cfg.exportTo (--export=<format>:<path>), leaving the original
+// datastore at path untouched. Intermediate hops, if any, are still
+// materialized through throwaway badger temp dirs so that later Steppers
+// can Detect/Export them.
+func (c *Process) exportDs(path string, from, to int) error {
+	format, sinkPath, err := parseExportFlag(c.cfg.exportTo)
+	if err != nil {
+		return err
+	}
+
+	cur := path
+	for i := from; i < to; i++ {
+		src, dst := steppers[i], steppers[i+1]
+		Log.Printf("Upgrading badger %s -> %s\n", src.Version(), dst.Version())
 
-		Log.Printf("Moving data to %s\n", temp)
-		n := 0
+		data, err := src.Export(c.ctx, cur, nil)
+		if err != nil {
+			return err
+		}
 
-		for entry := range data {
-			err := txn.Set(entry.key, entry.value)
+		if i < to-1 {
+			temp, err := ioutil.TempDir(c.path, "badger-")
 			if err != nil {
 				c.cancel()
 				return err
 			}
-
-			if n%1000 == 0 {
-				Log.Printf("%d entries done\r\x1b[A", n)
+			if err := dst.Import(c.ctx, temp, data); err != nil {
+				c.cancel()
+				return err
 			}
-			n++
+			cur = temp
+			continue
 		}
-		Log.Printf("%d entries done\n", n)
-		Log.Printf("Commiting transaction\n")
 
-		return txn.Commit(nil)
-	}()
+		return c.drainToSink(format, sinkPath, data)
+	}
+
+	// from == to: nothing to hop through, export the datastore as-is.
+	data, err := steppers[to].Export(c.ctx, cur, nil)
 	if err != nil {
 		return err
 	}
+	return c.drainToSink(format, sinkPath, data)
+}
 
-	backup, err := ioutil.TempDir(c.path, "badger-backup-")
+func (c *Process) drainToSink(format, sinkPath string, data <-chan keyValue) error {
+	sink, err := newSink(format, sinkPath)
 	if err != nil {
 		return err
 	}
-	if err = os.Remove(backup); err != nil {
+
+	Log.Printf("Exporting to %s:%s\n", format, sinkPath)
+	if err := drainInto(sink, data); err != nil {
 		return err
 	}
+	// A cancelled Export closes data early without an error, leaving a
+	// truncated archive behind; report the cancellation.
+	return c.ctx.Err()
+}
+
+// swapIn moves the backing directory of a live datastore at path aside and
+// replaces it with temp, leaving the original reachable under the
+// returned "badger-backup-" dir until the caller is confident the upgrade
+// worked.
+func (c *Process) swapIn(path, temp string) (string, error) {
+	backup, err := ioutil.TempDir(c.path, "badger-backup-")
+	if err != nil {
+		return "", err
+	}
+	if err = os.Remove(backup); err != nil {
+		return "", err
+	}
 
 	Log.Printf("Renaming '%s' to '%s'\n", path, backup)
 
 	if err = os.Rename(path, backup); err != nil {
-		return err
+		return "", err
 	}
 	Log.Printf("Renaming '%s' to '%s'\n", temp, path)
 
 	if err = os.Rename(temp, path); err != nil {
-		return err
+		return "", err
 	}
 
 	Log.Printf("Success\n")
@@ -223,7 +365,7 @@ func (c *Process) migrateData(data chan keyValue, path string) error {
 	Log.Printf("REMOVE '%s'", backup)
 	Log.Printf("^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^")
 
-	return nil
+	return backup, nil
 }
 
 func (c *Process) loadSpecs() ([]string, error) {
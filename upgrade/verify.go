@@ -0,0 +1,154 @@
+package upgrade
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"path/filepath"
+
+	blake2b "golang.org/x/crypto/blake2b"
+)
+
+const ManifestFile = "upgrade-manifest.json"
+
+// datastoreManifest summarizes one datastore's worth of verified content:
+// enough to catch truncation, corruption or a bad hop without storing the
+// data itself.
+type datastoreManifest struct {
+	Path         string   `json:"path"`
+	VersionChain []string `json:"versionChain"`
+	EntryCount   int64    `json:"entryCount"`
+	TotalBytes   int64    `json:"totalBytes"`
+	Hash         string   `json:"hash"` // hex BLAKE2b-256 over all entries, in key order
+}
+
+type manifest struct {
+	Datastores []datastoreManifest `json:"datastores"`
+}
+
+// Verify independently re-derives the manifest for every badger datastore
+// referenced by baseDir's datastore_spec, without performing an upgrade.
+// It is the --manifest-only entry point: a pre-flight fingerprint you can
+// diff against another machine's before trusting a migration, or against
+// a manifest Upgrade itself already wrote.
+func Verify(ctx context.Context, baseDir string) error {
+	p := &Process{path: baseDir}
+
+	if err := p.checkRepoVersion(); err != nil {
+		return err
+	}
+
+	paths, err := p.loadSpecs()
+	if err != nil {
+		return err
+	}
+
+	var m manifest
+	for _, dir := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		full := filepath.Join(p.path, dir)
+
+		idx, err := detectStepper(full)
+		if err != nil {
+			return err
+		}
+
+		dm, err := hashDatastore(ctx, full, []string{steppers[idx].Version()})
+		if err != nil {
+			return err
+		}
+		m.Datastores = append(m.Datastores, dm)
+	}
+
+	return writeManifest(p.path, m)
+}
+
+// hashDatastore exports every entry at path through its detected Stepper
+// and folds them into a single rolling BLAKE2b-256 hash. The hash is
+// order-dependent, so this forces a sequential Export (withSequentialExport)
+// the same way a checkpointed upgradeDs hop does: a Stepper whose Export
+// partitions the keyspace across several workers (badger08Stepper, when
+// not resuming) would otherwise feed entries in an arbitrary order, making
+// two hashes of the same data disagree for no real reason.
+func hashDatastore(ctx context.Context, path string, chain []string) (datastoreManifest, error) {
+	idx, err := detectStepper(path)
+	if err != nil {
+		return datastoreManifest{}, err
+	}
+
+	data, err := steppers[idx].Export(withSequentialExport(ctx), path, nil)
+	if err != nil {
+		return datastoreManifest{}, err
+	}
+
+	h, err := blake2b.New256(nil)
+	if err != nil {
+		return datastoreManifest{}, err
+	}
+
+	var count, total int64
+	for kv := range data {
+		writeHashFrame(h, kv.key, kv.value)
+		count++
+		total += int64(len(kv.key) + len(kv.value))
+	}
+
+	return datastoreManifest{
+		Path:         path,
+		VersionChain: chain,
+		EntryCount:   count,
+		TotalBytes:   total,
+		Hash:         hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+func writeHashFrame(h hash.Hash, key, value []byte) {
+	var lenBuf [8]byte
+	binary.BigEndian.PutUint32(lenBuf[0:4], uint32(len(key)))
+	binary.BigEndian.PutUint32(lenBuf[4:8], uint32(len(value)))
+	h.Write(lenBuf[:])
+	h.Write(key)
+	h.Write(value)
+}
+
+// verifyMigration hashes backupPath (the untouched pre-migration copy)
+// and path (the final migrated datastore), fails loudly on any mismatch,
+// and returns the manifest entry to record for path.
+func (c *Process) verifyMigration(path, backupPath string, chain []string) (datastoreManifest, error) {
+	Log.Printf("Verifying %s against backup %s\n", path, backupPath)
+
+	before, err := hashDatastore(c.ctx, backupPath, chain[:1])
+	if err != nil {
+		return datastoreManifest{}, err
+	}
+
+	after, err := hashDatastore(c.ctx, path, chain)
+	if err != nil {
+		return datastoreManifest{}, err
+	}
+
+	if before.EntryCount != after.EntryCount || before.TotalBytes != after.TotalBytes || before.Hash != after.Hash {
+		return datastoreManifest{}, fmt.Errorf(
+			"verification failed for %s: before={count=%d bytes=%d hash=%s} after={count=%d bytes=%d hash=%s}",
+			path, before.EntryCount, before.TotalBytes, before.Hash, after.EntryCount, after.TotalBytes, after.Hash)
+	}
+
+	Log.Printf("Verified %s: %d entries, %d bytes\n", path, after.EntryCount, after.TotalBytes)
+
+	return after, nil
+}
+
+func writeManifest(repoPath string, m manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(repoPath, ManifestFile), data, 0644)
+}